@@ -0,0 +1,22 @@
+// Copyright 2021 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package user
+
+import "fmt"
+
+// ErrUserSettingNotExist represents an error that a setting does not exist for a user
+type ErrUserSettingNotExist struct {
+	Key string
+}
+
+// IsErrUserSettingNotExist checks if an error is an ErrUserSettingNotExist
+func IsErrUserSettingNotExist(err error) bool {
+	_, ok := err.(ErrUserSettingNotExist)
+	return ok
+}
+
+func (err ErrUserSettingNotExist) Error() string {
+	return fmt.Sprintf("user setting does not exist [key: %s]", err.Key)
+}
@@ -7,9 +7,12 @@ package user
 import (
 	"context"
 	"fmt"
+	"strconv"
 	"strings"
 
 	"code.gitea.io/gitea/models/db"
+	"code.gitea.io/gitea/modules/cache"
+	"code.gitea.io/gitea/modules/setting"
 
 	"xorm.io/builder"
 )
@@ -31,6 +34,30 @@ func init() {
 	db.RegisterModel(new(Setting))
 }
 
+func settingCacheKey(userID int64, key string) string {
+	return fmt.Sprintf("user_setting:%d:%s", userID, key)
+}
+
+func settingCacheKeyAll(userID int64) string {
+	return fmt.Sprintf("user_setting:%d:all", userID)
+}
+
+func settingCachePut(key string, val interface{}) {
+	if !setting.CacheService.Enabled {
+		return
+	}
+	_ = cache.GetCache().Put(key, val, int64(setting.CacheService.TTL.Seconds()))
+}
+
+func settingCacheInvalidate(userID int64, key string) {
+	if !setting.CacheService.Enabled {
+		return
+	}
+	c := cache.GetCache()
+	_ = c.Delete(settingCacheKey(userID, key))
+	_ = c.Delete(settingCacheKeyAll(userID))
+}
+
 // GetSettings returns specific settings from user
 func GetSettings(uid int64, keys []string) (map[string]*Setting, error) {
 	settings := make([]*Setting, 0, len(keys))
@@ -47,8 +74,95 @@ func GetSettings(uid int64, keys []string) (map[string]*Setting, error) {
 	return settingsMap, nil
 }
 
-// GetUserAllSettings returns all settings from user
+// GetSetting returns a specific setting for a user, consulting the cache before the database. If no
+// row exists but the key was registered via RegisterSetting, its default value is returned instead
+// (without writing a row), so unset settings still read as their declared default. Migration of a
+// registered LegacyKey is only attempted on that miss, after the cache has already been consulted, so
+// a cache hit for key never pays for a migration check it doesn't need.
+func GetSetting(uid int64, key string) (*Setting, error) {
+	s, err := getSettingRow(uid, key)
+	if err == nil {
+		return s, nil
+	}
+	if !IsErrUserSettingNotExist(err) {
+		return nil, err
+	}
+
+	def, ok := GetSettingDef(key)
+	if !ok {
+		return nil, err
+	}
+
+	if def.LegacyKey != "" {
+		if err := migrateLegacyKey(uid, strings.ToLower(key), def); err != nil {
+			return nil, err
+		}
+		if s, err := getSettingRow(uid, key); err == nil {
+			return s, nil
+		} else if !IsErrUserSettingNotExist(err) {
+			return nil, err
+		}
+	}
+
+	return &Setting{UserID: uid, SettingKey: strings.ToLower(key), SettingValue: def.Default}, nil
+}
+
+// getSettingRow returns the actual persisted row for a user's setting, consulting the cache before
+// the database, and ErrUserSettingNotExist if no row exists -- regardless of any registered default.
+// The returned *Setting is always freshly allocated, never the pointer stored in the cache, so a
+// caller that mutates it in place (e.g. to apply a default for display without persisting) can't
+// corrupt what other readers see for the rest of the cache TTL.
+func getSettingRow(uid int64, key string) (*Setting, error) {
+	key = strings.ToLower(key)
+	cacheKey := settingCacheKey(uid, key)
+	if setting.CacheService.Enabled {
+		if v := cache.GetCache().Get(cacheKey); v != nil {
+			if s, ok := v.(*Setting); ok {
+				clone := *s
+				return &clone, nil
+			}
+		}
+	}
+
+	s := &Setting{}
+	has, err := db.GetEngine(db.DefaultContext).Where("user_id=? AND setting_key=?", uid, key).Get(s)
+	if err != nil {
+		return nil, err
+	} else if !has {
+		return nil, ErrUserSettingNotExist{Key: key}
+	}
+
+	cached := *s
+	settingCachePut(cacheKey, &cached)
+	return s, nil
+}
+
+// GetUserAllSettings returns all settings from user, consulting the cache before the database. On a
+// cache miss, any legacy keys the user still has set are migrated to their current name first, via
+// EnsureSettingsMigrated, so the result reflects renamed keys even ones the caller didn't ask for --
+// this runs after the cache check so a cache hit never pays for a migration pass it doesn't need. The
+// returned map and its *Setting values are always freshly allocated, never the ones stored in the
+// cache, so a caller that mutates one in place can't corrupt what other readers see for the rest of
+// the cache TTL.
 func GetUserAllSettings(uid int64) (map[string]*Setting, error) {
+	cacheKey := settingCacheKeyAll(uid)
+	if setting.CacheService.Enabled {
+		if v := cache.GetCache().Get(cacheKey); v != nil {
+			if settingsMap, ok := v.(map[string]*Setting); ok {
+				clone := make(map[string]*Setting, len(settingsMap))
+				for k, s := range settingsMap {
+					s := *s
+					clone[k] = &s
+				}
+				return clone, nil
+			}
+		}
+	}
+
+	if err := EnsureSettingsMigrated(uid); err != nil {
+		return nil, err
+	}
+
 	settings := make([]*Setting, 0, 5)
 	if err := db.GetEngine(db.DefaultContext).
 		Where("user_id=?", uid).
@@ -59,21 +173,52 @@ func GetUserAllSettings(uid int64) (map[string]*Setting, error) {
 	for _, s := range settings {
 		settingsMap[s.SettingKey] = s
 	}
+
+	cached := make(map[string]*Setting, len(settingsMap))
+	for k, s := range settingsMap {
+		s := *s
+		cached[k] = &s
+	}
+	settingCachePut(cacheKey, cached)
 	return settingsMap, nil
 }
 
 // DeleteSetting deletes a specific setting for a user
 func DeleteSetting(setting *Setting) error {
 	_, err := db.GetEngine(db.DefaultContext).Delete(setting)
-	return err
+	if err != nil {
+		return err
+	}
+	settingCacheInvalidate(setting.UserID, setting.SettingKey)
+	return nil
 }
 
-// SetSetting updates a users' setting for a specific key
+// SetSetting updates a users' setting for a specific key. If the key was registered via
+// RegisterSetting with a validator, values that fail it are rejected with ErrUserSettingInvalid.
 func SetSetting(setting *Setting) error {
 	if strings.ToLower(setting.SettingKey) != setting.SettingKey {
 		return fmt.Errorf("setting key should be lowercase")
 	}
-	return upsertSettingValue(setting.UserID, setting.SettingKey, setting.SettingValue)
+	if err := validateSettingValue(setting.SettingKey, setting.SettingValue); err != nil {
+		return err
+	}
+	if err := upsertSettingValue(setting.UserID, setting.SettingKey, setting.SettingValue); err != nil {
+		return err
+	}
+	settingCacheInvalidate(setting.UserID, setting.SettingKey)
+	return nil
+}
+
+// validateSettingValue rejects a candidate value with ErrUserSettingInvalid if key was registered
+// via RegisterSetting with a validator that it fails. It is shared by SetSetting and SetSettings so
+// the two write paths for user_setting enforce the same invariant.
+func validateSettingValue(key, value string) error {
+	if def, ok := GetSettingDef(key); ok && def.Validator != nil {
+		if err := def.Validator(value); err != nil {
+			return ErrUserSettingInvalid{Key: key, Reason: err.Error()}
+		}
+	}
+	return nil
 }
 
 func upsertSettingValue(userID int64, key string, value string) error {
@@ -114,3 +259,151 @@ func upsertSettingValue(userID int64, key string, value string) error {
 		return err
 	})
 }
+
+// likeEscaper escapes the wildcard characters '%' and '_' in a LIKE pattern operand so it is matched
+// literally, leaving the caller free to append its own '%' as a wildcard.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// GetSettingsByPrefix returns all settings from user whose key starts with the given prefix
+func GetSettingsByPrefix(uid int64, prefix string) (map[string]*Setting, error) {
+	settings := make([]*Setting, 0, 5)
+	if err := db.GetEngine(db.DefaultContext).
+		Where("user_id=?", uid).
+		And("setting_key LIKE ? ESCAPE '\\'", likeEscaper.Replace(prefix)+"%").
+		Find(&settings); err != nil {
+		return nil, err
+	}
+	settingsMap := make(map[string]*Setting)
+	for _, s := range settings {
+		settingsMap[s.SettingKey] = s
+	}
+	return settingsMap, nil
+}
+
+// SetSettings updates a users' settings for the given keys in a single transaction, so a page that
+// saves many preferences at once (notifications, diff view, editor) can't leave the row set partially
+// updated if one write fails.
+func SetSettings(userID int64, values map[string]string) error {
+	keys := make([]string, 0, len(values))
+	for key, value := range values {
+		if strings.ToLower(key) != key {
+			return fmt.Errorf("setting key should be lowercase")
+		}
+		if err := validateSettingValue(key, value); err != nil {
+			return err
+		}
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+
+	if err := db.WithTx(func(ctx context.Context) error {
+		e := db.GetEngine(ctx)
+
+		// same UPDATE-first-then-conditional-INSERT idiom as upsertSettingValue, applied per key:
+		// the UPDATE acquires the transaction write lock before we check existence, so two concurrent
+		// batch saves introducing the same new key can't both see "not found" and both INSERT.
+		for _, key := range keys {
+			value := values[key]
+
+			res, err := e.Exec("UPDATE user_setting SET setting_value=? WHERE setting_key=? AND user_id=?", value, key, userID)
+			if err != nil {
+				return err
+			}
+			if rows, _ := res.RowsAffected(); rows > 0 {
+				continue
+			}
+
+			has, err := e.Exist(&Setting{UserID: userID, SettingKey: key})
+			if err != nil {
+				return err
+			}
+			if has {
+				continue
+			}
+
+			if _, err := e.Insert(&Setting{UserID: userID, SettingKey: key, SettingValue: value}); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		settingCacheInvalidate(userID, key)
+	}
+	return nil
+}
+
+// DeleteSettings deletes the given settings for a user in a single transaction
+func DeleteSettings(userID int64, keys []string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+
+	if err := db.WithTx(func(ctx context.Context) error {
+		_, err := db.GetEngine(ctx).
+			Where("user_id=?", userID).
+			And(builder.In("setting_key", keys)).
+			Delete(&Setting{})
+		return err
+	}); err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		settingCacheInvalidate(userID, key)
+	}
+	return nil
+}
+
+// GetUserSetting returns the value of a user's setting, or defaultValue if it is not set. This reads
+// the row directly via getSettingRow rather than GetSetting, so a registered key's registry default
+// never shadows the caller-supplied defaultValue here.
+func GetUserSetting(userID int64, key, defaultValue string) (string, error) {
+	s, err := getSettingRow(userID, key)
+	if IsErrUserSettingNotExist(err) {
+		return defaultValue, nil
+	} else if err != nil {
+		return "", err
+	}
+	return s.SettingValue, nil
+}
+
+// GetUserSettingBool returns the boolean value of a user's setting, or defaultValue if it is not set or invalid
+func GetUserSettingBool(userID int64, key string, defaultValue bool) (bool, error) {
+	v, err := GetUserSetting(userID, key, strconv.FormatBool(defaultValue))
+	if err != nil {
+		return false, err
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return defaultValue, nil
+	}
+	return b, nil
+}
+
+// GetUserSettingInt64 returns the int64 value of a user's setting, or defaultValue if it is not set or invalid
+func GetUserSettingInt64(userID int64, key string, defaultValue int64) (int64, error) {
+	v, err := GetUserSetting(userID, key, strconv.FormatInt(defaultValue, 10))
+	if err != nil {
+		return 0, err
+	}
+	i, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return defaultValue, nil
+	}
+	return i, nil
+}
+
+// SetUserSetting sets a user's setting to the given value, creating or updating the row as needed
+func SetUserSetting(userID int64, key, value string) error {
+	return SetSetting(&Setting{
+		UserID:       userID,
+		SettingKey:   strings.ToLower(key),
+		SettingValue: value,
+	})
+}
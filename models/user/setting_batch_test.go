@@ -0,0 +1,99 @@
+// Copyright 2021 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package user
+
+import (
+	"fmt"
+	"testing"
+
+	"code.gitea.io/gitea/models/db"
+	"code.gitea.io/gitea/models/unittest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSetSettingsMixOfNewAndExistingKeys(t *testing.T) {
+	assert.NoError(t, unittest.PrepareTestEnv(t))
+
+	const userID = 1000
+	assert.NoError(t, SetSetting(&Setting{UserID: userID, SettingKey: "test.batch-existing", SettingValue: "old"}))
+
+	assert.NoError(t, SetSettings(userID, map[string]string{
+		"test.batch-existing": "new",
+		"test.batch-fresh":    "v1",
+	}))
+
+	settingsMap, err := GetSettings(userID, []string{"test.batch-existing", "test.batch-fresh"})
+	assert.NoError(t, err)
+	assert.Equal(t, "new", settingsMap["test.batch-existing"].SettingValue)
+	assert.Equal(t, "v1", settingsMap["test.batch-fresh"].SettingValue)
+}
+
+func TestSetSettingsRollsBackWholeBatchOnValidatorFailure(t *testing.T) {
+	assert.NoError(t, unittest.PrepareTestEnv(t))
+
+	const userID = 1000
+	const validatedKey = "test.batch-validated"
+	RegisterSetting(validatedKey, SettingDef{
+		Type:    SettingValueTypeString,
+		Default: "en-US",
+		Validator: func(value string) error {
+			if value != "en-US" && value != "de-DE" {
+				return fmt.Errorf("unsupported locale %q", value)
+			}
+			return nil
+		},
+	})
+
+	err := SetSettings(userID, map[string]string{
+		"test.batch-unvalidated": "anything",
+		validatedKey:             "xx-XX",
+	})
+	assert.True(t, IsErrUserSettingInvalid(err))
+
+	_, err = getSettingRow(userID, "test.batch-unvalidated")
+	assert.True(t, IsErrUserSettingNotExist(err), "no key of the batch must have been written when one of its values fails validation")
+}
+
+func TestDeleteSettingsRemovesAllGivenKeysInOneTransaction(t *testing.T) {
+	assert.NoError(t, unittest.PrepareTestEnv(t))
+
+	const userID = 1000
+	assert.NoError(t, SetSettings(userID, map[string]string{
+		"test.batch-delete-a": "1",
+		"test.batch-delete-b": "2",
+	}))
+
+	assert.NoError(t, DeleteSettings(userID, []string{"test.batch-delete-a", "test.batch-delete-b"}))
+
+	for _, key := range []string{"test.batch-delete-a", "test.batch-delete-b"} {
+		_, err := getSettingRow(userID, key)
+		assert.True(t, IsErrUserSettingNotExist(err))
+	}
+}
+
+func TestGetSettingsByPrefixMatchesLiteralPercentAndUnderscore(t *testing.T) {
+	assert.NoError(t, unittest.PrepareTestEnv(t))
+
+	const userID = 1000
+	assert.NoError(t, SetSettings(userID, map[string]string{
+		"test.100%_done":   "yes",
+		"test.100x_done":   "no",  // must not match the escaped '%' in the prefix below
+		"test.other-group": "no",
+	}))
+	defer func() {
+		_, _ = db.GetEngine(db.DefaultContext).Where("user_id=?", userID).
+			In("setting_key", "test.100%_done", "test.100x_done", "test.other-group").Delete(&Setting{})
+	}()
+
+	settingsMap, err := GetSettingsByPrefix(userID, "test.100%_")
+	assert.NoError(t, err)
+	_, matched := settingsMap["test.100%_done"]
+	assert.True(t, matched)
+	_, unmatched := settingsMap["test.100x_done"]
+	assert.False(t, unmatched, "escaped '%' and '_' in the prefix must be matched literally, not as wildcards")
+	_, other := settingsMap["test.other-group"]
+	assert.False(t, other)
+}
@@ -0,0 +1,117 @@
+// Copyright 2021 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package user
+
+import (
+	"testing"
+
+	"code.gitea.io/gitea/models/db"
+	"code.gitea.io/gitea/models/unittest"
+	"code.gitea.io/gitea/modules/setting"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUserSettingCacheServesStaleReadsUntilInvalidated(t *testing.T) {
+	assert.NoError(t, unittest.PrepareTestEnv(t))
+	if !setting.CacheService.Enabled {
+		t.Skip("cache service disabled in test config")
+	}
+
+	const userID = 1000
+	const key = "test.cache-layer"
+
+	assert.NoError(t, SetUserSetting(userID, key, "v1"))
+
+	// SetSetting only invalidates the cache, so this first read fills it from the database
+	v, err := GetUserSetting(userID, key, "")
+	assert.NoError(t, err)
+	assert.Equal(t, "v1", v)
+
+	// change the row directly in the database, bypassing SetSetting's cache invalidation, and
+	// confirm the read is served from the now-stale cache rather than hitting the database again
+	_, err = db.GetEngine(db.DefaultContext).Exec(
+		"UPDATE user_setting SET setting_value=? WHERE user_id=? AND setting_key=?", "v2-bypassing-cache", userID, key)
+	assert.NoError(t, err)
+
+	v, err = GetUserSetting(userID, key, "")
+	assert.NoError(t, err)
+	assert.Equal(t, "v1", v)
+
+	// going through SetSetting invalidates the cache, so the next read reflects the database again
+	assert.NoError(t, SetUserSetting(userID, key, "v3"))
+	v, err = GetUserSetting(userID, key, "")
+	assert.NoError(t, err)
+	assert.Equal(t, "v3", v)
+}
+
+func TestGetSettingRowCacheHitReturnsAnIndependentCopy(t *testing.T) {
+	assert.NoError(t, unittest.PrepareTestEnv(t))
+	if !setting.CacheService.Enabled {
+		t.Skip("cache service disabled in test config")
+	}
+
+	const userID = 1000
+	const key = "test.cache-aliasing"
+	assert.NoError(t, SetSetting(&Setting{UserID: userID, SettingKey: key, SettingValue: "v1"}))
+
+	first, err := getSettingRow(userID, key)
+	assert.NoError(t, err)
+	first.SettingValue = "mutated-in-place"
+
+	second, err := getSettingRow(userID, key)
+	assert.NoError(t, err)
+	assert.Equal(t, "v1", second.SettingValue, "mutating one caller's *Setting must not affect what other readers see from the cache")
+}
+
+func TestGetUserAllSettingsCacheHitReturnsAnIndependentCopy(t *testing.T) {
+	assert.NoError(t, unittest.PrepareTestEnv(t))
+	if !setting.CacheService.Enabled {
+		t.Skip("cache service disabled in test config")
+	}
+
+	const userID = 1000
+	const key = "test.cache-aliasing-all"
+	assert.NoError(t, SetSetting(&Setting{UserID: userID, SettingKey: key, SettingValue: "v1"}))
+
+	first, err := GetUserAllSettings(userID)
+	assert.NoError(t, err)
+	first[key].SettingValue = "mutated-in-place"
+	first["test.cache-aliasing-all-extra"] = &Setting{UserID: userID, SettingKey: "test.cache-aliasing-all-extra", SettingValue: "injected"}
+
+	second, err := GetUserAllSettings(userID)
+	assert.NoError(t, err)
+	assert.Equal(t, "v1", second[key].SettingValue, "mutating one caller's *Setting must not affect what other readers see from the cache")
+	_, injected := second["test.cache-aliasing-all-extra"]
+	assert.False(t, injected, "mutating one caller's map must not affect what other readers see from the cache")
+}
+
+func TestGetUserSettingTypedAccessors(t *testing.T) {
+	assert.NoError(t, unittest.PrepareTestEnv(t))
+
+	const userID = 1000
+
+	v, err := GetUserSetting(userID, "test.typed-unset-string", "fallback")
+	assert.NoError(t, err)
+	assert.Equal(t, "fallback", v)
+
+	assert.NoError(t, SetUserSetting(userID, "test.typed-bool", "true"))
+	b, err := GetUserSettingBool(userID, "test.typed-bool", false)
+	assert.NoError(t, err)
+	assert.True(t, b)
+
+	bUnset, err := GetUserSettingBool(userID, "test.typed-bool-unset", true)
+	assert.NoError(t, err)
+	assert.True(t, bUnset)
+
+	assert.NoError(t, SetUserSetting(userID, "test.typed-int", "42"))
+	i, err := GetUserSettingInt64(userID, "test.typed-int", 7)
+	assert.NoError(t, err)
+	assert.EqualValues(t, 42, i)
+
+	iUnset, err := GetUserSettingInt64(userID, "test.typed-int-unset", 7)
+	assert.NoError(t, err)
+	assert.EqualValues(t, 7, iUnset)
+}
@@ -0,0 +1,158 @@
+// Copyright 2021 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package user
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"code.gitea.io/gitea/models/db"
+)
+
+// SettingValueType describes the underlying value type of a registered user setting
+type SettingValueType string
+
+// Supported user setting value types
+const (
+	SettingValueTypeString SettingValueType = "string"
+	SettingValueTypeBool   SettingValueType = "bool"
+	SettingValueTypeInt64  SettingValueType = "int64"
+	SettingValueTypeEnum   SettingValueType = "enum"
+	SettingValueTypeJSON   SettingValueType = "json"
+)
+
+// SettingValidator validates a candidate value before SetSetting is allowed to persist it
+type SettingValidator func(value string) error
+
+// SettingDef describes a user setting owned by a subsystem: its default value, its type, an
+// optional validator, and an optional legacy key it replaces.
+type SettingDef struct {
+	Type      SettingValueType
+	Default   string
+	Validator SettingValidator
+	LegacyKey string
+}
+
+var settingRegistry = map[string]SettingDef{}
+
+// RegisterSetting lets a subsystem (markup, editor, notifications, ...) declare a setting key and
+// its default up front, in code, instead of scattering string literals and defaults across callers.
+// It should be called from the owning package's init() func, before any user settings are read.
+func RegisterSetting(key string, def SettingDef) {
+	key = strings.ToLower(key)
+	if _, ok := settingRegistry[key]; ok {
+		panic(fmt.Sprintf("user setting %q is already registered", key))
+	}
+	settingRegistry[key] = def
+}
+
+// GetSettingDef returns the registered definition for a key, if any
+func GetSettingDef(key string) (SettingDef, bool) {
+	def, ok := settingRegistry[strings.ToLower(key)]
+	return def, ok
+}
+
+// ListSettingDefs returns a copy of the full setting registry, keyed by setting key
+func ListSettingDefs() map[string]SettingDef {
+	defs := make(map[string]SettingDef, len(settingRegistry))
+	for key, def := range settingRegistry {
+		defs[key] = def
+	}
+	return defs
+}
+
+// ErrUserSettingInvalid represents an error that a candidate value failed a registered setting's validator
+type ErrUserSettingInvalid struct {
+	Key    string
+	Reason string
+}
+
+// IsErrUserSettingInvalid checks if an error is an ErrUserSettingInvalid
+func IsErrUserSettingInvalid(err error) bool {
+	_, ok := err.(ErrUserSettingInvalid)
+	return ok
+}
+
+func (err ErrUserSettingInvalid) Error() string {
+	return fmt.Sprintf("invalid value for user setting [key: %s]: %s", err.Key, err.Reason)
+}
+
+// EnsureSettingsMigrated renames every legacy key (declared via SettingDef.LegacyKey) that this user
+// still has set under its old name to the current key. It is idempotent and is the bulk counterpart
+// of the per-key migration GetSetting does when a read finds no row; GetUserAllSettings calls this
+// before listing so a full dump also reflects renamed keys, even ones it hasn't been asked for yet.
+func EnsureSettingsMigrated(userID int64) error {
+	for key, def := range settingRegistry {
+		if err := migrateLegacyKey(userID, key, def); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrateLegacyKey renames a single legacy key (if def declares one, and this user still has a value
+// under it) to key, without clobbering a value the user already has under key, in one transaction so
+// a mid-migration failure can't leave the new key set with the legacy row still present. Called from
+// GetSetting when a read of a key that declares a LegacyKey finds no row, and from EnsureSettingsMigrated for a
+// bulk pass over the whole registry.
+func migrateLegacyKey(userID int64, key string, def SettingDef) error {
+	if def.LegacyKey == "" {
+		return nil
+	}
+
+	legacy, err := getSettingRow(userID, def.LegacyKey)
+	if IsErrUserSettingNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	if _, err := getSettingRow(userID, key); err == nil {
+		// the new key already has a value, don't clobber it with the legacy one
+		return nil
+	} else if !IsErrUserSettingNotExist(err) {
+		return err
+	}
+
+	if err := validateSettingValue(key, legacy.SettingValue); err != nil {
+		// the legacy value doesn't satisfy key's validator (e.g. a tightened enum); leave the
+		// legacy row in place rather than failing every future read of key on this
+		return nil
+	}
+
+	if err := db.WithTx(func(ctx context.Context) error {
+		e := db.GetEngine(ctx)
+
+		// inlined against the outer ctx's engine, not a call to upsertSettingValue: that helper
+		// opens its own db.WithTx, and nesting a second transaction inside this one's callback
+		// would block waiting on the write lock this transaction already holds (single-writer
+		// DBs) or commit independently of it (multi-connection DBs), defeating the atomicity the
+		// Delete below depends on.
+		res, err := e.Exec("UPDATE user_setting SET setting_value=? WHERE setting_key=? AND user_id=?", legacy.SettingValue, key, userID)
+		if err != nil {
+			return err
+		}
+		if rows, _ := res.RowsAffected(); rows == 0 {
+			has, err := e.Exist(&Setting{UserID: userID, SettingKey: key})
+			if err != nil {
+				return err
+			}
+			if !has {
+				if _, err := e.Insert(&Setting{UserID: userID, SettingKey: key, SettingValue: legacy.SettingValue}); err != nil {
+					return err
+				}
+			}
+		}
+
+		_, err = e.Delete(legacy)
+		return err
+	}); err != nil {
+		return err
+	}
+	settingCacheInvalidate(userID, key)
+	settingCacheInvalidate(userID, legacy.SettingKey)
+	return nil
+}
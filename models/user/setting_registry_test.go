@@ -0,0 +1,77 @@
+// Copyright 2021 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package user
+
+import (
+	"testing"
+
+	"code.gitea.io/gitea/models/unittest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegisterSettingPanicsOnDuplicate(t *testing.T) {
+	const key = "test.duplicate-setting"
+	RegisterSetting(key, SettingDef{Type: SettingValueTypeString, Default: "a"})
+	assert.Panics(t, func() {
+		RegisterSetting(key, SettingDef{Type: SettingValueTypeString, Default: "b"})
+	})
+}
+
+func TestListSettingDefsIsACopy(t *testing.T) {
+	const key = "test.list-setting-defs-copy"
+	RegisterSetting(key, SettingDef{Type: SettingValueTypeString, Default: "a"})
+
+	defs := ListSettingDefs()
+	defs[key] = SettingDef{Type: SettingValueTypeString, Default: "mutated"}
+
+	def, ok := GetSettingDef(key)
+	assert.True(t, ok)
+	assert.Equal(t, "a", def.Default, "mutating the map returned by ListSettingDefs must not affect the registry")
+}
+
+func TestMigrateLegacyKeyNoopWithoutLegacyKey(t *testing.T) {
+	// a SettingDef with no LegacyKey is a no-op and never touches the database
+	assert.NoError(t, migrateLegacyKey(1, "test.no-legacy-key", SettingDef{Default: "x"}))
+}
+
+func TestMigrateLegacyKeyRenamesExistingLegacyRow(t *testing.T) {
+	assert.NoError(t, unittest.PrepareTestEnv(t))
+
+	const userID = 1000
+	const key = "test.migrate-new-key"
+	const legacyKey = "test.migrate-old-key"
+
+	assert.NoError(t, SetSetting(&Setting{UserID: userID, SettingKey: legacyKey, SettingValue: "v1"}))
+
+	assert.NoError(t, migrateLegacyKey(userID, key, SettingDef{Default: "fallback", LegacyKey: legacyKey}))
+
+	s, err := getSettingRow(userID, key)
+	assert.NoError(t, err)
+	assert.Equal(t, "v1", s.SettingValue)
+
+	_, err = getSettingRow(userID, legacyKey)
+	assert.True(t, IsErrUserSettingNotExist(err), "the legacy row must be gone once migrated")
+}
+
+func TestMigrateLegacyKeyDoesNotClobberExistingNewKey(t *testing.T) {
+	assert.NoError(t, unittest.PrepareTestEnv(t))
+
+	const userID = 1000
+	const key = "test.migrate-no-clobber-new-key"
+	const legacyKey = "test.migrate-no-clobber-old-key"
+
+	assert.NoError(t, SetSetting(&Setting{UserID: userID, SettingKey: legacyKey, SettingValue: "legacy-value"}))
+	assert.NoError(t, SetSetting(&Setting{UserID: userID, SettingKey: key, SettingValue: "current-value"}))
+
+	assert.NoError(t, migrateLegacyKey(userID, key, SettingDef{Default: "fallback", LegacyKey: legacyKey}))
+
+	s, err := getSettingRow(userID, key)
+	assert.NoError(t, err)
+	assert.Equal(t, "current-value", s.SettingValue, "an existing value under the new key must not be overwritten by the legacy one")
+
+	_, err = getSettingRow(userID, legacyKey)
+	assert.NoError(t, err, "the legacy row is left in place when it isn't migrated")
+}
@@ -0,0 +1,34 @@
+// Copyright 2021 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package user
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateSettingValue(t *testing.T) {
+	const key = "test.validate-setting-value"
+	RegisterSetting(key, SettingDef{
+		Type:    SettingValueTypeString,
+		Default: "en-US",
+		Validator: func(value string) error {
+			if value != "en-US" && value != "de-DE" {
+				return fmt.Errorf("unsupported locale %q", value)
+			}
+			return nil
+		},
+	})
+
+	assert.NoError(t, validateSettingValue(key, "de-DE"))
+
+	err := validateSettingValue(key, "xx-XX")
+	assert.True(t, IsErrUserSettingInvalid(err))
+
+	// a key with no registered definition (or no validator) is never rejected here
+	assert.NoError(t, validateSettingValue("test.unregistered-key", "anything"))
+}
@@ -6,14 +6,91 @@ package hostmatcher
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net"
+	"net/http"
+	"net/url"
+	"sync"
 	"syscall"
 	"time"
 )
 
-// NewDialContext returns a DialContext for Transport, the DialContext will do allow/block list check
-func NewDialContext(usage string, allowList *HostMatchList, blockList *HostMatchList) func(ctx context.Context, network, addr string) (net.Conn, error) {
+// DialOptions controls the behavior of a dialer created by NewDialContextWithOptions.
+type DialOptions struct {
+	Timeout   time.Duration
+	KeepAlive time.Duration
+
+	// MaxRetries is how many additional dial attempts to make after a temporary net.Error, using
+	// exponential backoff. Zero (the default) disables retries.
+	MaxRetries int
+
+	// PinIP makes the dialer stash the first IP it resolves for a given host into the dial ctx (see
+	// WithIPPinning) and refuse to dial that host again with a different IP for the lifetime of that
+	// ctx. This defeats DNS-rebinding: a redirect chain that re-resolves the same host mid-flight
+	// can't swap a passed allow/block check for an internal IP on a later hop.
+	PinIP bool
+}
+
+var defaultDialOptions = DialOptions{
+	// default values come from http.DefaultTransport
+	Timeout:   30 * time.Second,
+	KeepAlive: 30 * time.Second,
+}
+
+const (
+	dialRetryInitialBackoff = 200 * time.Millisecond
+	dialRetryMaxBackoff     = 5 * time.Second
+)
+
+type pinnedIPsKey struct{}
+
+// pinnedIPs is stashed in the dial ctx by WithIPPinning and shared by every dial made with that ctx
+// (e.g. across a redirect chain), so a host's resolved IP is looked up once and reused/verified
+// afterwards instead of being re-resolved -- and potentially rebound -- on every hop.
+type pinnedIPs struct {
+	mu  sync.Mutex
+	ips map[string]net.IP
+}
+
+// WithIPPinning returns a ctx that dialers created with DialOptions.PinIP will use to remember, and
+// verify against, each host's first-resolved IP. Pass the *same* ctx into every request that belongs
+// to one logical redirect chain; a fresh context.Background() gives each request its own pinning.
+func WithIPPinning(ctx context.Context) context.Context {
+	return context.WithValue(ctx, pinnedIPsKey{}, &pinnedIPs{ips: map[string]net.IP{}})
+}
+
+// pinAndCheckIP pins ip as host's resolved address on pinned if it isn't pinned yet, or otherwise
+// checks ip against the already-pinned address, returning an error that identifies usage and host if
+// they differ. pinned == nil (IP pinning disabled) is always a no-op.
+func pinAndCheckIP(pinned *pinnedIPs, usage, host string, ip net.IP) error {
+	if pinned == nil {
+		return nil
+	}
+	pinned.mu.Lock()
+	pinnedIP, seen := pinned.ips[host]
+	if !seen {
+		pinned.ips[host] = ip
+	}
+	pinned.mu.Unlock()
+	if seen && !pinnedIP.Equal(ip) {
+		return fmt.Errorf("%s detected a DNS rebind for host '%s': pinned to %s, resolved to %s", usage, host, pinnedIP, ip)
+	}
+	return nil
+}
+
+// NewDialContext returns a DialContext for Transport, the DialContext will do allow/block list check.
+// If proxy is non-nil, the dial target is the proxy's own address rather than the final host, so the
+// IP-based allow/block check below is skipped here: use NewTransport, which enforces the allow/block
+// lists against the final request host via the Transport's Proxy func instead.
+func NewDialContext(usage string, allowList, blockList *HostMatchList, proxy *url.URL) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return NewDialContextWithOptions(usage, allowList, blockList, proxy, defaultDialOptions)
+}
+
+// NewDialContextWithOptions is like NewDialContext but lets the caller tune timeouts, opt into a
+// bounded retry of transient dial failures (e.g. connection resets during webhook delivery), and pin
+// the resolved IP of a dial ctx obtained via WithIPPinning against DNS rebinding.
+func NewDialContextWithOptions(usage string, allowList, blockList *HostMatchList, proxy *url.URL, opts DialOptions) func(ctx context.Context, network, addr string) (net.Conn, error) {
 	// How Go HTTP Client works with redirection:
 	//   transport.RoundTrip URL=http://domain.com, Host=domain.com
 	//   transport.DialContext addrOrHost=domain.com:80
@@ -22,12 +99,44 @@ func NewDialContext(usage string, allowList *HostMatchList, blockList *HostMatch
 	//   transport.DialContext addrOrHost=domain.com:80
 	//   dialer.Control tcp4:11.22.33.44:80
 	return func(ctx context.Context, network, addrOrHost string) (net.Conn, error) {
+		var pinned *pinnedIPs
+		if opts.PinIP {
+			pinned, _ = ctx.Value(pinnedIPsKey{}).(*pinnedIPs)
+		}
+
+		// resolveDialAddr returns addrOrHost, unless this host was already resolved-and-checked
+		// once on this ctx (either an earlier retry below, or an earlier hop of the same redirect
+		// chain), in which case it dials that exact IP again instead of re-resolving -- so a
+		// round-robin/CDN host with several valid A records doesn't trip the rebind check in
+		// Control below, and a real rebind attempt never gets a second resolution to exploit.
+		resolveDialAddr := func() string {
+			if pinned == nil {
+				return addrOrHost
+			}
+			host, port, err := net.SplitHostPort(addrOrHost)
+			if err != nil {
+				return addrOrHost
+			}
+			pinned.mu.Lock()
+			pinnedIP, seen := pinned.ips[host]
+			pinned.mu.Unlock()
+			if !seen {
+				return addrOrHost
+			}
+			return net.JoinHostPort(pinnedIP.String(), port)
+		}
+
 		dialer := net.Dialer{
-			// default values comes from http.DefaultTransport
-			Timeout:   30 * time.Second,
-			KeepAlive: 30 * time.Second,
+			Timeout:   opts.Timeout,
+			KeepAlive: opts.KeepAlive,
 
 			Control: func(network, ipAddr string, c syscall.RawConn) (err error) {
+				if proxy != nil {
+					// addrOrHost is the proxy's address here, not the final target host, so it
+					// can't be checked against the allow/block list at this layer.
+					return nil
+				}
+
 				var host string
 				if host, _, err = net.SplitHostPort(addrOrHost); err != nil {
 					return err
@@ -38,6 +147,15 @@ func NewDialContext(usage string, allowList *HostMatchList, blockList *HostMatch
 					return fmt.Errorf("%s can only call HTTP servers via TCP, deny '%s(%s:%s)', err=%v", usage, host, network, ipAddr, err)
 				}
 
+				// this is the single point where a host's IP is resolved-and-checked; stash it so
+				// later dials for the same host (see resolveDialAddr above) reuse it instead of
+				// re-resolving. A mismatch here means this host already has a different IP pinned
+				// on this ctx (two dials for it resolved concurrently before either was pinned, or
+				// this dial moved on to another of the host's addresses) -- treat it as a rebind.
+				if err := pinAndCheckIP(pinned, usage, host, tcpAddr.IP); err != nil {
+					return err
+				}
+
 				var blockedError error
 				if blockList.MatchHostOrIP(host, tcpAddr.IP) {
 					blockedError = fmt.Errorf("%s can not call blocked HTTP servers (check your %s setting), deny '%s(%s)'", usage, blockList.SettingKeyHint, host, ipAddr)
@@ -53,6 +171,72 @@ func NewDialContext(usage string, allowList *HostMatchList, blockList *HostMatch
 				return blockedError
 			},
 		}
-		return dialer.DialContext(ctx, network, addrOrHost)
+
+		// retries only ever wrap dialer.DialContext itself: once a conn is handed back to the
+		// caller and bytes start flowing on it, this loop is done and never touches it again.
+		backoff := dialRetryInitialBackoff
+		for attempt := 0; ; attempt++ {
+			conn, err := dialer.DialContext(ctx, network, resolveDialAddr())
+			if err == nil {
+				return conn, nil
+			}
+
+			var netErr net.Error
+			if attempt >= opts.MaxRetries || !errors.As(err, &netErr) || !netErr.Temporary() {
+				return nil, err
+			}
+
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-time.After(backoff):
+			}
+			if backoff *= 2; backoff > dialRetryMaxBackoff {
+				backoff = dialRetryMaxBackoff
+			}
+		}
+	}
+}
+
+// NewTransport returns an *http.Transport whose dialer enforces the allow/block lists against the
+// direct dial target. When proxy is non-nil, the dialer only ever sees the proxy's own address, so
+// this also installs a Proxy func that enforces the allow/block lists against the final request
+// host before handing the request off to the proxy -- otherwise a block-list rule on the final host
+// (e.g. to keep webhooks off internal IPs) would silently stop applying once a proxy is configured.
+func NewTransport(usage string, allowList, blockList *HostMatchList, proxy *url.URL) *http.Transport {
+	return NewTransportWithOptions(usage, allowList, blockList, proxy, defaultDialOptions)
+}
+
+// NewTransportWithOptions is like NewTransport but lets the caller opt into the retry and
+// DNS-rebind-pinning behavior of NewDialContextWithOptions. Callers that pass DialOptions.PinIP
+// should also derive their request contexts from WithIPPinning so a redirect chain shares one
+// pinned-IP store.
+func NewTransportWithOptions(usage string, allowList, blockList *HostMatchList, proxy *url.URL, opts DialOptions) *http.Transport {
+	t := &http.Transport{
+		DialContext: NewDialContextWithOptions(usage, allowList, blockList, proxy, opts),
+	}
+	if proxy == nil {
+		return t
+	}
+
+	t.Proxy = func(req *http.Request) (*url.URL, error) {
+		host := req.URL.Hostname()
+
+		var blockedError error
+		if blockList.MatchHostOrIP(host, nil) {
+			blockedError = fmt.Errorf("%s can not call blocked HTTP servers (check your %s setting), deny '%s'", usage, blockList.SettingKeyHint, host)
+		}
+
+		if !allowList.IsEmpty() {
+			if !allowList.MatchHostOrIP(host, nil) {
+				return nil, fmt.Errorf("%s can only call allowed HTTP servers (check your %s setting), deny '%s'", usage, allowList.SettingKeyHint, host)
+			}
+		}
+
+		if blockedError != nil {
+			return nil, blockedError
+		}
+		return proxy, nil
 	}
+	return t
 }
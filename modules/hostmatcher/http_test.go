@@ -0,0 +1,81 @@
+// Copyright 2021 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package hostmatcher
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewDialContextWithOptionsIPPinningReusesPinnedIP(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+
+	dial := NewDialContextWithOptions("test", nil, nil, nil, DialOptions{PinIP: true, MaxRetries: 2})
+	ctx := WithIPPinning(context.Background())
+
+	conn, err := dial(ctx, "tcp", ln.Addr().String())
+	assert.NoError(t, err)
+	conn.Close()
+
+	pinned, ok := ctx.Value(pinnedIPsKey{}).(*pinnedIPs)
+	assert.True(t, ok)
+	pinned.mu.Lock()
+	pinnedIP, seen := pinned.ips["127.0.0.1"]
+	pinned.mu.Unlock()
+	assert.True(t, seen)
+	assert.True(t, pinnedIP.Equal(net.ParseIP("127.0.0.1")))
+
+	// dialing the same host again should reuse the pinned IP rather than erroring
+	conn2, err := dial(ctx, "tcp", ln.Addr().String())
+	assert.NoError(t, err)
+	conn2.Close()
+}
+
+func TestNewDialContextWithOptionsNoRetryOnPermanentError(t *testing.T) {
+	// bind then immediately close, so the port is refused rather than accepted -- connection
+	// refused is a permanent error (not a net.Error with Temporary()==true), so the dial must
+	// fail on the first attempt without waiting through the retry backoff
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	addr := ln.Addr().String()
+	assert.NoError(t, ln.Close())
+
+	dial := NewDialContextWithOptions("test", nil, nil, nil, DialOptions{MaxRetries: 3})
+
+	start := time.Now()
+	_, err = dial(context.Background(), "tcp", addr)
+	assert.Error(t, err)
+	assert.Less(t, time.Since(start), dialRetryInitialBackoff)
+}
+
+func TestPinAndCheckIPRejectsDNSRebind(t *testing.T) {
+	pinned := &pinnedIPs{ips: map[string]net.IP{}}
+
+	// first resolution for this host pins it
+	assert.NoError(t, pinAndCheckIP(pinned, "test", "example.com", net.ParseIP("203.0.113.1")))
+
+	// same IP again is a no-op
+	assert.NoError(t, pinAndCheckIP(pinned, "test", "example.com", net.ParseIP("203.0.113.1")))
+
+	// a later resolution for the same host returning a different IP -- e.g. the second lookup of a
+	// redirect chain being rebound to an internal address -- must be rejected, not silently accepted
+	err := pinAndCheckIP(pinned, "test", "example.com", net.ParseIP("127.0.0.1"))
+	assert.ErrorContains(t, err, "detected a DNS rebind")
+}
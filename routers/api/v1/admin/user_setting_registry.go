@@ -0,0 +1,48 @@
+// Copyright 2021 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package admin
+
+import (
+	"net/http"
+	"sort"
+
+	"code.gitea.io/gitea/models/user"
+	"code.gitea.io/gitea/modules/context"
+)
+
+// userSettingDef is the API-facing representation of a registered user setting
+type userSettingDef struct {
+	Key       string `json:"key"`
+	Type      string `json:"type"`
+	Default   string `json:"default"`
+	LegacyKey string `json:"legacy_key,omitempty"`
+}
+
+// ListUserSettingRegistry dumps the settings subsystems have registered via user.RegisterSetting, so
+// operators can see which keys exist, what they default to, and whether they migrate an older key,
+// without grepping every package's init() func.
+func ListUserSettingRegistry(ctx *context.APIContext) {
+	// swagger:operation GET /admin/user-settings/registry admin adminListUserSettingRegistry
+	// ---
+	// summary: List the registered user setting definitions
+	// produces:
+	// - application/json
+	// responses:
+	//   "200":
+	//     description: registered user setting definitions
+
+	defs := user.ListSettingDefs()
+	result := make([]*userSettingDef, 0, len(defs))
+	for key, def := range defs {
+		result = append(result, &userSettingDef{
+			Key:       key,
+			Type:      string(def.Type),
+			Default:   def.Default,
+			LegacyKey: def.LegacyKey,
+		})
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Key < result[j].Key })
+	ctx.JSON(http.StatusOK, result)
+}
@@ -0,0 +1,20 @@
+// Copyright 2021 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package v1
+
+import (
+	"code.gitea.io/gitea/modules/web"
+	"code.gitea.io/gitea/routers/api/v1/admin"
+)
+
+// registerUserSettingRegistryRoute adds the "/user-settings" group, with GET "/registry" served by
+// admin.ListUserSettingRegistry, to m. The path documented for that handler,
+// /admin/user-settings/registry, holds when m is the "/admin" group (token required, site admin
+// only). Nothing in this tree calls it.
+func registerUserSettingRegistryRoute(m *web.Route) {
+	m.Group("/user-settings", func() {
+		m.Get("/registry", admin.ListUserSettingRegistry)
+	})
+}